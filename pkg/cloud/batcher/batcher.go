@@ -0,0 +1,120 @@
+// Package batcher coalesces many small per-key requests arriving close
+// together into a single batched call, then fans the result back out to
+// each caller. It exists because NIFCLOUD computing's DescribeInstances is
+// rate-limited: under fan-out (e.g. a StatefulSet attaching N volumes at
+// once) issuing one DescribeInstances per caller is both slow and likely
+// to get throttled, while a single call covering all of their instance
+// IDs is cheap.
+package batcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Result is what a single key resolves to once its batch has run.
+type Result struct {
+	Value interface{}
+	Err   error
+}
+
+// BatchFunc executes a single batch covering keys and returns a Result
+// for as many of them as it could resolve. A key missing from the
+// returned map is treated as an unexplained failure by the Batcher, so
+// implementations should make a best effort to include one entry per key
+// even when the underlying call only partially succeeds.
+type BatchFunc func(keys []string) (map[string]Result, error)
+
+// Batcher coalesces Do calls for distinct keys arriving within maxDelay
+// of each other (or until maxBatchSize distinct keys are pending,
+// whichever comes first) into a single BatchFunc call.
+type Batcher struct {
+	maxDelay     time.Duration
+	maxBatchSize int
+	batchFn      BatchFunc
+
+	mux     sync.Mutex
+	pending map[string][]chan Result
+	timer   *time.Timer
+}
+
+// New creates a Batcher that flushes pending keys to batchFn after
+// maxDelay, or immediately once maxBatchSize distinct keys are pending.
+func New(maxDelay time.Duration, maxBatchSize int, batchFn BatchFunc) *Batcher {
+	return &Batcher{
+		maxDelay:     maxDelay,
+		maxBatchSize: maxBatchSize,
+		batchFn:      batchFn,
+		pending:      make(map[string][]chan Result),
+	}
+}
+
+// Do requests the result for key, joining whatever batch is currently
+// being assembled (or starting a new one), and blocks until that batch
+// has run or ctx is cancelled.
+func (b *Batcher) Do(ctx context.Context, key string) (interface{}, error) {
+	ch := make(chan Result, 1)
+
+	b.mux.Lock()
+	wasEmpty := len(b.pending) == 0
+	b.pending[key] = append(b.pending[key], ch)
+	shouldFlush := len(b.pending) >= b.maxBatchSize
+	if shouldFlush {
+		if b.timer != nil {
+			b.timer.Stop()
+			b.timer = nil
+		}
+	} else if wasEmpty {
+		b.timer = time.AfterFunc(b.maxDelay, b.flush)
+	}
+	b.mux.Unlock()
+
+	if shouldFlush {
+		b.flush()
+	}
+
+	select {
+	case res := <-ch:
+		return res.Value, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flush takes whatever is currently pending, runs batchFn once for it,
+// and delivers a Result to every waiting caller. It's safe to call
+// concurrently (e.g. from both the delay timer and a full batch) since
+// the pending set is swapped out atomically under the lock.
+func (b *Batcher) flush() {
+	b.mux.Lock()
+	pending := b.pending
+	b.pending = make(map[string][]chan Result)
+	b.timer = nil
+	b.mux.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+
+	results, err := b.batchFn(keys)
+	for key, chans := range pending {
+		res, ok := results[key]
+		if !ok {
+			res = Result{Err: err}
+			if res.Err == nil {
+				res.Err = fmt.Errorf("batcher: no result returned for key %q", key)
+			}
+		}
+		for _, ch := range chans {
+			ch <- res
+			close(ch)
+		}
+	}
+}
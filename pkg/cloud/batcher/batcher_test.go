@@ -0,0 +1,133 @@
+package batcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoCoalescesConcurrentCallers(t *testing.T) {
+	var calls int32
+
+	b := New(50*time.Millisecond, 50, func(keys []string) (map[string]Result, error) {
+		atomic.AddInt32(&calls, 1)
+		results := make(map[string]Result, len(keys))
+		for _, key := range keys {
+			results[key] = Result{Value: key + "-resolved"}
+		}
+		return results, nil
+	})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	values := make([]interface{}, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = b.Do(context.Background(), "instance-a")
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("caller %d: unexpected error: %v", i, err)
+		}
+		if values[i] != "instance-a-resolved" {
+			t.Fatalf("caller %d: got %v, want instance-a-resolved", i, values[i])
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("batchFn called %d times, want 1", got)
+	}
+}
+
+func TestDoFlushesOnFullBatch(t *testing.T) {
+	started := make(chan struct{})
+	b := New(time.Hour, 2, func(keys []string) (map[string]Result, error) {
+		close(started)
+		results := make(map[string]Result, len(keys))
+		for _, key := range keys {
+			results[key] = Result{Value: key}
+		}
+		return results, nil
+	})
+
+	go func() { _, _ = b.Do(context.Background(), "a") }()
+	go func() { _, _ = b.Do(context.Background(), "b") }()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("batchFn was not called once maxBatchSize was reached")
+	}
+}
+
+func TestDoReturnsPartialFailures(t *testing.T) {
+	b := New(20*time.Millisecond, 50, func(keys []string) (map[string]Result, error) {
+		results := make(map[string]Result, len(keys))
+		for _, key := range keys {
+			if key == "bad" {
+				results[key] = Result{Err: fmt.Errorf("describe failed for %s", key)}
+				continue
+			}
+			results[key] = Result{Value: key}
+		}
+		return results, nil
+	})
+
+	var wg sync.WaitGroup
+	var goodVal, badVal interface{}
+	var goodErr, badErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		goodVal, goodErr = b.Do(context.Background(), "good")
+	}()
+	go func() {
+		defer wg.Done()
+		badVal, badErr = b.Do(context.Background(), "bad")
+	}()
+	wg.Wait()
+
+	if goodErr != nil || goodVal != "good" {
+		t.Fatalf("good key: got (%v, %v), want (good, nil)", goodVal, goodErr)
+	}
+	if badErr == nil {
+		t.Fatal("bad key: expected an error, got nil")
+	}
+	if badVal != nil {
+		t.Fatalf("bad key: expected nil value, got %v", badVal)
+	}
+}
+
+func TestDoCancellation(t *testing.T) {
+	b := New(time.Hour, 50, func(keys []string) (map[string]Result, error) {
+		t.Fatal("batchFn should not run before the context is cancelled")
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := b.Do(ctx, "never-flushed")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestDoMissingResultIsAnError(t *testing.T) {
+	b := New(20*time.Millisecond, 50, func(keys []string) (map[string]Result, error) {
+		// Simulate a batch call that silently drops a key.
+		return map[string]Result{}, nil
+	})
+
+	_, err := b.Do(context.Background(), "dropped")
+	if err == nil {
+		t.Fatal("expected an error for a key missing from the batch result, got nil")
+	}
+}
@@ -0,0 +1,187 @@
+// Package cloud wraps the NIFCLOUD computing API calls this driver makes,
+// adding the cross-cutting behavior (request coalescing, idempotency)
+// that a single SDK call doesn't give you for free.
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alice02/nifcloud-sdk-go-v2/service/computing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/aokumasan/nifcloud-additional-storage-csi-driver/pkg/cloud/batcher"
+	"github.com/aokumasan/nifcloud-additional-storage-csi-driver/pkg/cloud/devicemanager"
+	"github.com/aokumasan/nifcloud-additional-storage-csi-driver/pkg/cloud/token"
+)
+
+const (
+	// describeInstancesBatchDelay is how long GetInstance waits for other
+	// callers to join a DescribeInstances batch before issuing it.
+	describeInstancesBatchDelay = 250 * time.Millisecond
+
+	// describeInstancesMaxBatch caps how many instance IDs go into a
+	// single DescribeInstances call.
+	describeInstancesMaxBatch = 50
+
+	// createVolumeTokenTTL is how long CreateVolume's idempotency token
+	// cache reuses a token for retries of the same request before a new
+	// one would be minted anyway.
+	createVolumeTokenTTL = 10 * time.Minute
+)
+
+// ComputingAPI is the subset of the NIFCLOUD computing client this
+// package calls. Its methods take this package's own argument types
+// (e.g. CreateVolumeInput), so *computing.Client from
+// github.com/alice02/nifcloud-sdk-go-v2 does not satisfy it directly; it
+// has to be wrapped in an adapter.
+type ComputingAPI interface {
+	DescribeInstances(ctx context.Context, instanceIDs []string) ([]computing.InstancesSetItem, error)
+
+	// CreateVolume creates an additional-storage volume idempotently:
+	// a repeated call with the same clientToken must not create a
+	// second volume. If clientToken was already used to create a volume
+	// with different parameters, it returns an
+	// *IdempotentParameterMismatchError.
+	CreateVolume(ctx context.Context, clientToken string, input CreateVolumeInput) (volumeID string, err error)
+}
+
+// CreateVolumeInput is the subset of a CSI CreateVolumeRequest needed to
+// create an additional-storage volume and to compute its idempotency
+// token.
+type CreateVolumeInput struct {
+	Name             string
+	SizeGiB          int64
+	VolumeType       string
+	AvailabilityZone string
+	Encrypted        bool
+
+	// MultiAttach requests a volume that may be attached to more than one
+	// node at once (CSI AccessMode MULTI_NODE_MULTI_WRITER). CreateVolume
+	// rejects this unless devicemanager.IsMultiAttachAllowed(VolumeType).
+	MultiAttach bool
+}
+
+func (i CreateVolumeInput) tokenParams() token.Params {
+	return token.Params{
+		Name:             i.Name,
+		SizeGiB:          i.SizeGiB,
+		VolumeType:       i.VolumeType,
+		AvailabilityZone: i.AvailabilityZone,
+		Encrypted:        i.Encrypted,
+	}
+}
+
+// IdempotentParameterMismatchError is returned by ComputingAPI.CreateVolume
+// when clientToken was already used to create a volume whose parameters
+// don't match this request.
+type IdempotentParameterMismatchError struct {
+	ConflictingVolumeID string
+}
+
+func (e *IdempotentParameterMismatchError) Error() string {
+	return fmt.Sprintf("idempotent parameter mismatch against existing volume %q", e.ConflictingVolumeID)
+}
+
+// Cloud is the driver's handle onto NIFCLOUD computing. Every exported
+// method is safe for concurrent use.
+type Cloud struct {
+	api             ComputingAPI
+	describeBatcher *batcher.Batcher
+	tokens          *token.Cache
+}
+
+// New wraps api, coalescing concurrent DescribeInstances lookups for
+// individual instances into as few underlying calls as possible and
+// generating idempotency tokens for CreateVolume. Use WithCreateVolumeTokenTTL
+// to override how long a minted token is reused; it defaults to
+// createVolumeTokenTTL.
+func New(api ComputingAPI, opts ...Option) *Cloud {
+	options := &cloudOptions{createVolumeTokenTTL: createVolumeTokenTTL}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	c := &Cloud{api: api, tokens: token.NewCache(options.createVolumeTokenTTL)}
+	c.describeBatcher = batcher.New(describeInstancesBatchDelay, describeInstancesMaxBatch, c.describeInstances)
+	return c
+}
+
+// CreateVolume creates an additional-storage volume for input, using a
+// client token derived from input's parameters so that CSI retries of
+// the same request are idempotent. If a prior partial create left
+// behind an orphan volume with different parameters under the same
+// name, the first attempt will come back as a parameter mismatch; this
+// refreshes the token once and retries, and only gives up with a
+// descriptive error naming the conflicting volume if the mismatch
+// persists, instead of looping on the same token forever.
+//
+// input.MultiAttach is rejected up front for any VolumeType that
+// devicemanager.IsMultiAttachAllowed doesn't allow; this is the only
+// multi-attach enforcement in this tree today, not a substitute for the
+// ControllerPublishVolume-side checks devicemanager.DeviceManager's doc
+// comment calls out as still missing.
+func (c *Cloud) CreateVolume(ctx context.Context, input CreateVolumeInput) (string, error) {
+	if input.MultiAttach && !devicemanager.IsMultiAttachAllowed(input.VolumeType) {
+		return "", fmt.Errorf("volume type %q does not support multi-attach", input.VolumeType)
+	}
+
+	params := input.tokenParams()
+
+	tok, _ := c.tokens.Token(params)
+	volumeID, err := c.api.CreateVolume(ctx, tok, input)
+
+	var mismatchErr *IdempotentParameterMismatchError
+	if errors.As(err, &mismatchErr) {
+		tok = c.tokens.Refresh(params)
+		volumeID, err = c.api.CreateVolume(ctx, tok, input)
+		if errors.As(err, &mismatchErr) {
+			return "", token.MismatchError(input.Name, mismatchErr.ConflictingVolumeID)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return volumeID, nil
+}
+
+// GetInstance returns the current state of instanceID. Calls arriving
+// within describeInstancesBatchDelay of each other (up to
+// describeInstancesMaxBatch at a time) are folded into a single
+// DescribeInstances request, so a StatefulSet of N replicas all
+// attaching at once doesn't turn into N separate, individually
+// rate-limited API calls.
+func (c *Cloud) GetInstance(ctx context.Context, instanceID string) (*computing.InstancesSetItem, error) {
+	v, err := c.describeBatcher.Do(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, ok := v.(*computing.InstancesSetItem)
+	if !ok || instance == nil {
+		return nil, fmt.Errorf("no instance found for %q", instanceID)
+	}
+	return instance, nil
+}
+
+// describeInstances is the describeBatcher's BatchFunc: it issues a
+// single DescribeInstances call covering every instance ID requested
+// since the last flush and fans the results back out by ID. The
+// DeviceManager stays oblivious to any of this, receiving a fully
+// populated InstancesSetItem exactly as it did before batching existed.
+func (c *Cloud) describeInstances(instanceIDs []string) (map[string]batcher.Result, error) {
+	instances, err := c.api.DescribeInstances(context.Background(), instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]batcher.Result, len(instances))
+	for i := range instances {
+		instance := instances[i]
+		results[aws.StringValue(instance.InstanceId)] = batcher.Result{Value: &instance}
+	}
+	return results, nil
+}
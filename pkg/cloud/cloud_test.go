@@ -0,0 +1,250 @@
+package cloud
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alice02/nifcloud-sdk-go-v2/service/computing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/aokumasan/nifcloud-additional-storage-csi-driver/pkg/cloud/devicemanager"
+)
+
+type fakeComputingAPI struct {
+	calls int32
+	fn    func(instanceIDs []string) ([]computing.InstancesSetItem, error)
+
+	createVolumeCalls int32
+	createVolumeFn    func(clientToken string, input CreateVolumeInput) (string, error)
+}
+
+func (f *fakeComputingAPI) DescribeInstances(_ context.Context, instanceIDs []string) ([]computing.InstancesSetItem, error) {
+	atomic.AddInt32(&f.calls, 1)
+	return f.fn(instanceIDs)
+}
+
+func (f *fakeComputingAPI) CreateVolume(_ context.Context, clientToken string, input CreateVolumeInput) (string, error) {
+	atomic.AddInt32(&f.createVolumeCalls, 1)
+	return f.createVolumeFn(clientToken, input)
+}
+
+func TestGetInstanceCoalescesConcurrentCallers(t *testing.T) {
+	api := &fakeComputingAPI{
+		fn: func(instanceIDs []string) ([]computing.InstancesSetItem, error) {
+			out := make([]computing.InstancesSetItem, 0, len(instanceIDs))
+			for _, id := range instanceIDs {
+				out = append(out, computing.InstancesSetItem{InstanceId: aws.String(id)})
+			}
+			return out, nil
+		},
+	}
+	c := New(api)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = c.GetInstance(context.Background(), "i-shared")
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("caller %d: unexpected error: %v", i, err)
+		}
+	}
+	if got := atomic.LoadInt32(&api.calls); got != 1 {
+		t.Fatalf("DescribeInstances called %d times, want 1", got)
+	}
+}
+
+func TestGetInstanceReturnsErrorForUnknownInstance(t *testing.T) {
+	api := &fakeComputingAPI{
+		fn: func(instanceIDs []string) ([]computing.InstancesSetItem, error) {
+			return nil, nil
+		},
+	}
+	c := New(api)
+
+	if _, err := c.GetInstance(context.Background(), "i-missing"); err == nil {
+		t.Fatal("expected an error for an instance DescribeInstances didn't return, got nil")
+	}
+}
+
+func TestGetInstanceBatchesSeparateCallsWithinTheDelayWindow(t *testing.T) {
+	api := &fakeComputingAPI{
+		fn: func(instanceIDs []string) ([]computing.InstancesSetItem, error) {
+			out := make([]computing.InstancesSetItem, 0, len(instanceIDs))
+			for _, id := range instanceIDs {
+				out = append(out, computing.InstancesSetItem{InstanceId: aws.String(id)})
+			}
+			return out, nil
+		},
+	}
+	c := New(api)
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"i-a", "i-b", "i-c"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := c.GetInstance(context.Background(), id); err != nil {
+				t.Errorf("GetInstance(%q): %v", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&api.calls); got != 1 {
+		t.Fatalf("DescribeInstances called %d times for 3 distinct instances within the batch window, want 1", got)
+	}
+
+	// A call arriving after the batch has already flushed starts a new one.
+	time.Sleep(describeInstancesBatchDelay + 50*time.Millisecond)
+	if _, err := c.GetInstance(context.Background(), "i-d"); err != nil {
+		t.Fatalf("GetInstance(i-d): %v", err)
+	}
+	if got := atomic.LoadInt32(&api.calls); got != 2 {
+		t.Fatalf("DescribeInstances called %d times overall, want 2", got)
+	}
+}
+
+func TestCreateVolumeIsIdempotentForIdenticalRetries(t *testing.T) {
+	var gotTokens []string
+	api := &fakeComputingAPI{
+		createVolumeFn: func(clientToken string, input CreateVolumeInput) (string, error) {
+			gotTokens = append(gotTokens, clientToken)
+			return "vol-1", nil
+		},
+	}
+	c := New(api)
+	input := CreateVolumeInput{Name: "pvc-1", SizeGiB: 20, VolumeType: "standard"}
+
+	first, err := c.CreateVolume(context.Background(), input)
+	if err != nil {
+		t.Fatalf("first CreateVolume: %v", err)
+	}
+	second, err := c.CreateVolume(context.Background(), input)
+	if err != nil {
+		t.Fatalf("retried CreateVolume: %v", err)
+	}
+
+	if first != "vol-1" || second != "vol-1" {
+		t.Fatalf("got volume IDs %q, %q, want vol-1 both times", first, second)
+	}
+	if len(gotTokens) != 2 || gotTokens[0] != gotTokens[1] {
+		t.Fatalf("expected the same client token on both calls, got %v", gotTokens)
+	}
+}
+
+func TestCreateVolumeRefreshesTokenOnParameterMismatch(t *testing.T) {
+	var gotTokens []string
+	attempt := 0
+	api := &fakeComputingAPI{
+		createVolumeFn: func(clientToken string, input CreateVolumeInput) (string, error) {
+			gotTokens = append(gotTokens, clientToken)
+			attempt++
+			if attempt == 1 {
+				return "", &IdempotentParameterMismatchError{ConflictingVolumeID: "vol-orphan"}
+			}
+			return "vol-2", nil
+		},
+	}
+	c := New(api)
+	input := CreateVolumeInput{Name: "pvc-1", SizeGiB: 20, VolumeType: "standard"}
+
+	volumeID, err := c.CreateVolume(context.Background(), input)
+	if err != nil {
+		t.Fatalf("expected the refreshed-token retry to succeed, got error: %v", err)
+	}
+	if volumeID != "vol-2" {
+		t.Fatalf("got volume %q, want vol-2", volumeID)
+	}
+	if len(gotTokens) != 2 || gotTokens[0] == gotTokens[1] {
+		t.Fatalf("expected a different token on the retry, got %v", gotTokens)
+	}
+}
+
+func TestCreateVolumeReturnsConflictingVolumeIDOnPersistentMismatch(t *testing.T) {
+	api := &fakeComputingAPI{
+		createVolumeFn: func(clientToken string, input CreateVolumeInput) (string, error) {
+			return "", &IdempotentParameterMismatchError{ConflictingVolumeID: "vol-orphan"}
+		},
+	}
+	c := New(api)
+	input := CreateVolumeInput{Name: "pvc-1", SizeGiB: 20, VolumeType: "standard"}
+
+	_, err := c.CreateVolume(context.Background(), input)
+	if err == nil {
+		t.Fatal("expected an error when the mismatch persists after a token refresh, got nil")
+	}
+	if !strings.Contains(err.Error(), "vol-orphan") {
+		t.Fatalf("expected the error to name the conflicting volume, got: %v", err)
+	}
+	if atomic.LoadInt32(&api.createVolumeCalls) != 2 {
+		t.Fatalf("expected exactly one retry (2 calls total), got %d", api.createVolumeCalls)
+	}
+}
+
+func TestWithCreateVolumeTokenTTLOverridesTheDefault(t *testing.T) {
+	var gotTokens []string
+	api := &fakeComputingAPI{
+		createVolumeFn: func(clientToken string, input CreateVolumeInput) (string, error) {
+			gotTokens = append(gotTokens, clientToken)
+			return "vol-1", nil
+		},
+	}
+	c := New(api, WithCreateVolumeTokenTTL(10*time.Millisecond))
+	input := CreateVolumeInput{Name: "pvc-1", SizeGiB: 20, VolumeType: "standard"}
+
+	if _, err := c.CreateVolume(context.Background(), input); err != nil {
+		t.Fatalf("first CreateVolume: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := c.CreateVolume(context.Background(), input); err != nil {
+		t.Fatalf("second CreateVolume: %v", err)
+	}
+
+	if len(gotTokens) != 2 || gotTokens[0] == gotTokens[1] {
+		t.Fatalf("expected a short TTL to force a fresh token once it expired, got %v", gotTokens)
+	}
+}
+
+func TestCreateVolumeRejectsMultiAttachForDisallowedVolumeType(t *testing.T) {
+	api := &fakeComputingAPI{
+		createVolumeFn: func(clientToken string, input CreateVolumeInput) (string, error) {
+			t.Fatal("CreateVolume should not reach the API for a disallowed multi-attach request")
+			return "", nil
+		},
+	}
+	c := New(api)
+	input := CreateVolumeInput{Name: "pvc-1", SizeGiB: 20, VolumeType: "standard", MultiAttach: true}
+
+	if _, err := c.CreateVolume(context.Background(), input); err == nil {
+		t.Fatal("expected an error for a multi-attach request against a volume type that doesn't allow it")
+	}
+}
+
+func TestCreateVolumeAllowsMultiAttachForAnAllowedVolumeType(t *testing.T) {
+	devicemanager.MultiAttachVolumeTypes["shared-disk"] = true
+	defer delete(devicemanager.MultiAttachVolumeTypes, "shared-disk")
+
+	api := &fakeComputingAPI{
+		createVolumeFn: func(clientToken string, input CreateVolumeInput) (string, error) {
+			return "vol-1", nil
+		},
+	}
+	c := New(api)
+	input := CreateVolumeInput{Name: "pvc-1", SizeGiB: 20, VolumeType: "shared-disk", MultiAttach: true}
+
+	if _, err := c.CreateVolume(context.Background(), input); err != nil {
+		t.Fatalf("expected a multi-attach request for an allowed volume type to succeed, got: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package devicemanager
+
+import (
+	"sync"
+	"time"
+)
+
+// badNameTTL is how long a device name stays excluded from the candidate
+// set on a node after it is reported bad. An hour is enough to ride out a
+// stuck external attachment without permanently losing the letter if
+// whatever grabbed it out-of-band eventually goes away.
+const badNameTTL = time.Hour
+
+// badNameCache remembers device names that recently failed to attach on a
+// given node, e.g. because something outside the driver (the console,
+// another controller, a manual attach) already occupies them. NewDevice
+// consults it so the same doomed name isn't reselected on every retry.
+// Entries expire on their own, and node entries are pruned once they go
+// empty so nodes coming and going don't leak memory.
+//
+// Everything is guarded by a single mutex rather than a sync.Map per
+// node: a per-node sync.Map let a concurrent Mark race with Names'
+// empty-check-then-delete of the node entry, silently dropping the name
+// Mark had just recorded. One lock makes "is this node's set empty" and
+// "remove the node's entry" atomic with Mark's read-or-create.
+type badNameCache struct {
+	mux   sync.Mutex
+	nodes map[string]map[string]time.Time // nodeID -> deviceName -> expiry
+}
+
+func newBadNameCache() *badNameCache {
+	return &badNameCache{
+		nodes: make(map[string]map[string]time.Time),
+	}
+}
+
+// Mark records that name failed to attach on nodeID and should be avoided
+// until it expires.
+func (c *badNameCache) Mark(nodeID, name string) {
+	c.MarkUntil(nodeID, name, time.Now().Add(badNameTTL))
+}
+
+// MarkUntil is Mark with an explicit expiry, for callers that need a
+// horizon other than badNameTTL (e.g. reconciling a persisted
+// reservation against its own GC horizon at startup).
+func (c *badNameCache) MarkUntil(nodeID, name string, expiresAt time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	names, ok := c.nodes[nodeID]
+	if !ok {
+		names = make(map[string]time.Time)
+		c.nodes[nodeID] = names
+	}
+	names[name] = expiresAt
+}
+
+// Names returns the set of device names currently marked bad for nodeID,
+// pruning any entries that have expired (and the node entry itself, once
+// it has none left) along the way.
+func (c *badNameCache) Names(nodeID string) map[string]bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	names, ok := c.nodes[nodeID]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	bad := map[string]bool{}
+	for name, expiry := range names {
+		if now.After(expiry) {
+			delete(names, name)
+			continue
+		}
+		bad[name] = true
+	}
+
+	if len(names) == 0 {
+		delete(c.nodes, nodeID)
+	}
+
+	return bad
+}
@@ -0,0 +1,70 @@
+package devicemanager
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBadNameCacheMarkAndNames(t *testing.T) {
+	c := newBadNameCache()
+
+	c.Mark("node-a", "/dev/sdb")
+
+	bad := c.Names("node-a")
+	if !bad["/dev/sdb"] {
+		t.Fatalf("expected /dev/sdb to be marked bad, got %v", bad)
+	}
+
+	if bad := c.Names("node-b"); len(bad) != 0 {
+		t.Fatalf("node-b should have no bad names, got %v", bad)
+	}
+}
+
+func TestBadNameCacheExpiresEntries(t *testing.T) {
+	c := newBadNameCache()
+	c.nodes["node-a"] = map[string]time.Time{
+		"/dev/sdb": time.Now().Add(-time.Minute), // already expired
+	}
+
+	if bad := c.Names("node-a"); len(bad) != 0 {
+		t.Fatalf("expected expired name to be pruned, got %v", bad)
+	}
+
+	// The node entry itself should have been dropped once it went empty.
+	c.mux.Lock()
+	_, ok := c.nodes["node-a"]
+	c.mux.Unlock()
+	if ok {
+		t.Fatal("expected empty node entry to be pruned from the cache")
+	}
+}
+
+func TestBadNameCacheConcurrentMarkDuringPrune(t *testing.T) {
+	c := newBadNameCache()
+
+	// Seed an entry that is already expired so the first Names call will
+	// prune the node entry.
+	c.nodes["node-a"] = map[string]time.Time{
+		"/dev/sdb": time.Now().Add(-time.Minute),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.Names("node-a")
+	}()
+	go func() {
+		defer wg.Done()
+		c.Mark("node-a", "/dev/sdc")
+	}()
+	wg.Wait()
+
+	// Regardless of ordering, a Mark that happened concurrently with a
+	// prune must not be lost.
+	bad := c.Names("node-a")
+	if !bad["/dev/sdc"] {
+		t.Fatalf("expected /dev/sdc marked bad to survive a concurrent prune, got %v", bad)
+	}
+}
@@ -0,0 +1,127 @@
+package devicemanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// reservationsDataKey is the ConfigMap data key the whole reservation set
+// is marshalled under.
+const reservationsDataKey = "reservations"
+
+// configMapStore is a Store backed by a Kubernetes ConfigMap, for
+// deployments where the controller pod can land on any node and a local
+// file wouldn't be seen by the next attempt.
+type configMapStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapStore creates a Store that persists reservations in the
+// data of the ConfigMap namespace/name, creating it on first write if it
+// doesn't already exist.
+func NewConfigMapStore(client kubernetes.Interface, namespace, name string) Store {
+	return &configMapStore{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (s *configMapStore) Load() (map[string]map[string]reservation, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(context.Background(), s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]map[string]reservation{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+
+	raw, ok := cm.Data[reservationsDataKey]
+	if !ok || raw == "" {
+		return map[string]map[string]reservation{}, nil
+	}
+
+	all := map[string]map[string]reservation{}
+	if err := json.Unmarshal([]byte(raw), &all); err != nil {
+		return nil, fmt.Errorf("could not parse ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+	return all, nil
+}
+
+func (s *configMapStore) Save(nodeID, name string, r reservation) error {
+	return s.mutate(func(all map[string]map[string]reservation) {
+		node, ok := all[nodeID]
+		if !ok {
+			node = map[string]reservation{}
+			all[nodeID] = node
+		}
+		node[name] = r
+	})
+}
+
+func (s *configMapStore) Delete(nodeID, name string) error {
+	return s.mutate(func(all map[string]map[string]reservation) {
+		if node, ok := all[nodeID]; ok {
+			delete(node, name)
+			if len(node) == 0 {
+				delete(all, nodeID)
+			}
+		}
+	})
+}
+
+// mutate loads the current reservation set, applies fn, and writes the
+// ConfigMap back, creating it if this is the first write.
+func (s *configMapStore) mutate(fn func(all map[string]map[string]reservation)) error {
+	ctx := context.Background()
+
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	notFound := apierrors.IsNotFound(err)
+	if err != nil && !notFound {
+		return fmt.Errorf("could not get ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+	if notFound {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.name,
+				Namespace: s.namespace,
+			},
+		}
+	}
+
+	all := map[string]map[string]reservation{}
+	if raw, ok := cm.Data[reservationsDataKey]; ok && raw != "" {
+		if err := json.Unmarshal([]byte(raw), &all); err != nil {
+			return fmt.Errorf("could not parse ConfigMap %s/%s: %v", s.namespace, s.name, err)
+		}
+	}
+
+	fn(all)
+
+	data, err := json.Marshal(all)
+	if err != nil {
+		return fmt.Errorf("could not marshal reservations: %v", err)
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[reservationsDataKey] = string(data)
+
+	if notFound {
+		_, err = s.client.CoreV1().ConfigMaps(s.namespace).Create(ctx, cm, metav1.CreateOptions{})
+	} else {
+		_, err = s.client.CoreV1().ConfigMaps(s.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("could not persist ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package devicemanager
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapStoreSaveLoadDelete(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := NewConfigMapStore(client, "kube-system", "nifcloud-csi-inflight")
+
+	r := reservation{VolumeID: "vol-1", ReservedAt: time.Now().Truncate(time.Second)}
+	if err := store.Save("node-a", "/dev/sdb", r); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	all, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	got, ok := all["node-a"]["/dev/sdb"]
+	if !ok {
+		t.Fatalf("expected a reservation for node-a//dev/sdb, got %v", all)
+	}
+	if got.VolumeID != r.VolumeID || !got.ReservedAt.Equal(r.ReservedAt) {
+		t.Fatalf("got %+v, want %+v", got, r)
+	}
+
+	if err := store.Delete("node-a", "/dev/sdb"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	all, err = store.Load()
+	if err != nil {
+		t.Fatalf("Load after Delete: %v", err)
+	}
+	if _, ok := all["node-a"]; ok {
+		t.Fatalf("expected node-a to be removed once its last reservation was deleted, got %v", all)
+	}
+}
+
+func TestConfigMapStoreLoadBeforeAnySaveReturnsEmptyMap(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := NewConfigMapStore(client, "kube-system", "nifcloud-csi-inflight")
+
+	all, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("expected an empty reservation set when the ConfigMap doesn't exist yet, got %v", all)
+	}
+}
+
+func TestConfigMapStoreCreatesConfigMapOnFirstSave(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := NewConfigMapStore(client, "kube-system", "nifcloud-csi-inflight")
+
+	if err := store.Save("node-a", "/dev/sdb", reservation{VolumeID: "vol-1", ReservedAt: time.Now()}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	// A second Save must update the existing ConfigMap rather than fail
+	// trying to create it again.
+	if err := store.Save("node-a", "/dev/sdc", reservation{VolumeID: "vol-2", ReservedAt: time.Now()}); err != nil {
+		t.Fatalf("second Save: %v", err)
+	}
+
+	all, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(all["node-a"]) != 2 {
+		t.Fatalf("expected 2 reservations for node-a, got %v", all["node-a"])
+	}
+}
@@ -0,0 +1,111 @@
+package devicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultStateDir is where the file-backed Store keeps its state when the
+// caller doesn't supply one via WithStateDir.
+const defaultStateDir = "/var/lib/nifcloud-additional-storage-csi-driver"
+
+const reservationsFileName = "inflight-reservations.json"
+
+// fileStore is the default Store: it keeps the whole reservation set as a
+// single JSON file under stateDir, rewritten atomically on every change.
+// It's adequate for the single active controller pod this driver runs as
+// today; NewConfigMapStore is available for deployments that want the
+// state to survive the node the controller pod lands on too.
+type fileStore struct {
+	mux  sync.Mutex
+	path string
+}
+
+// NewFileStore creates a Store that persists reservations to a JSON file
+// under stateDir.
+func NewFileStore(stateDir string) (Store, error) {
+	if err := os.MkdirAll(stateDir, 0750); err != nil {
+		return nil, fmt.Errorf("could not create state dir %q: %v", stateDir, err)
+	}
+	return &fileStore{path: filepath.Join(stateDir, reservationsFileName)}, nil
+}
+
+func (s *fileStore) Load() (map[string]map[string]reservation, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.load()
+}
+
+func (s *fileStore) load() (map[string]map[string]reservation, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return map[string]map[string]reservation{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read state file %q: %v", s.path, err)
+	}
+
+	if len(data) == 0 {
+		return map[string]map[string]reservation{}, nil
+	}
+
+	all := map[string]map[string]reservation{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("could not parse state file %q: %v", s.path, err)
+	}
+	return all, nil
+}
+
+func (s *fileStore) save(all map[string]map[string]reservation) error {
+	data, err := json.Marshal(all)
+	if err != nil {
+		return fmt.Errorf("could not marshal reservations: %v", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return fmt.Errorf("could not write state file %q: %v", tmp, err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *fileStore) Save(nodeID, name string, r reservation) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	node, ok := all[nodeID]
+	if !ok {
+		node = map[string]reservation{}
+		all[nodeID] = node
+	}
+	node[name] = r
+
+	return s.save(all)
+}
+
+func (s *fileStore) Delete(nodeID, name string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if node, ok := all[nodeID]; ok {
+		delete(node, name)
+		if len(node) == 0 {
+			delete(all, nodeID)
+		}
+	}
+
+	return s.save(all)
+}
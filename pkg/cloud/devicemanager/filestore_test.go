@@ -0,0 +1,80 @@
+package devicemanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileStoreSaveLoadDelete(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	r := reservation{VolumeID: "vol-1", ReservedAt: time.Now().Truncate(time.Second)}
+	if err := store.Save("node-a", "/dev/sdb", r); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	all, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	got, ok := all["node-a"]["/dev/sdb"]
+	if !ok {
+		t.Fatalf("expected a reservation for node-a//dev/sdb, got %v", all)
+	}
+	if got.VolumeID != r.VolumeID || !got.ReservedAt.Equal(r.ReservedAt) {
+		t.Fatalf("got %+v, want %+v", got, r)
+	}
+
+	if err := store.Delete("node-a", "/dev/sdb"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	all, err = store.Load()
+	if err != nil {
+		t.Fatalf("Load after Delete: %v", err)
+	}
+	if _, ok := all["node-a"]; ok {
+		t.Fatalf("expected node-a to be removed once its last reservation was deleted, got %v", all)
+	}
+}
+
+func TestFileStoreLoadOnEmptyStateDirReturnsEmptyMap(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	all, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("expected an empty reservation set before any Save, got %v", all)
+	}
+}
+
+func TestFileStorePersistsAcrossInstances(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := first.Save("node-a", "/dev/sdb", reservation{VolumeID: "vol-1", ReservedAt: time.Now()}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	second, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore (reopen): %v", err)
+	}
+	all, err := second.Load()
+	if err != nil {
+		t.Fatalf("Load (reopen): %v", err)
+	}
+	if _, ok := all["node-a"]["/dev/sdb"]; !ok {
+		t.Fatalf("expected a Store reopened at the same stateDir to see the prior reservation, got %v", all)
+	}
+}
@@ -1,9 +1,11 @@
 package devicemanager
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/alice02/nifcloud-sdk-go-v2/service/computing"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -18,6 +20,14 @@ type Device struct {
 	VolumeID          string
 	IsAlreadyAssigned bool
 
+	// MultiAttach records that this device was reserved for a volume
+	// whose AccessMode is MULTI_NODE_MULTI_WRITER. It is bookkeeping
+	// only: NewDevice keys the reservation by (nodeID, name) whatever its
+	// value, and it is not itself a safety check, since whether volumeID's type may be multi-attached
+	// at all must already have been validated by IsMultiAttachAllowed
+	// before this Device was requested.
+	MultiAttach bool
+
 	isTainted   bool
 	releaseFunc func() error
 }
@@ -38,11 +48,33 @@ func (d *Device) Taint() {
 type DeviceManager interface {
 	// NewDevice retrieves the device if the device is already assigned.
 	// Otherwise it creates a new device with next available device name
-	// and mark it as unassigned device.
-	NewDevice(instance *computing.InstancesSetItem, volumeID string) (device *Device, err error)
+	// and mark it as unassigned device. multiAttach must only be true
+	// for volume types IsMultiAttachAllowed returns true for; it is
+	// recorded on the returned Device for the caller's bookkeeping, but
+	// the in-flight reservation itself is still keyed by (nodeID, name)
+	// exactly as before. That happens to already be enough for two
+	// different nodes to each get their own device name for the same
+	// multi-attach volumeID, since getDeviceNamesInUse only ever looks
+	// at the instance passed in. It is NOT enough on its own: nothing
+	// here rejects ControllerPublishVolume for a second node when
+	// volumeID isn't actually multi-attach-eligible, enforces
+	// MULTI_NODE_MULTI_WRITER vs. single-writer AccessMode, or rejects
+	// Filesystem-mode multi-attach. Those checks belong to, and must be
+	// added in, the controller's ControllerPublishVolume/CreateVolume
+	// handlers.
+	NewDevice(instance *computing.InstancesSetItem, volumeID string, multiAttach bool) (device *Device, err error)
 
 	// GetDevice returns the device already assigned to the volume.
-	GetDevice(instance *computing.InstancesSetItem, volumeID string) (device *Device, err error)
+	// multiAttach must be passed the same value the volume was reserved
+	// with via NewDevice, so the returned Device.MultiAttach reflects
+	// reality instead of silently reporting false for a volume that
+	// actually is multi-attach.
+	GetDevice(instance *computing.InstancesSetItem, volumeID string, multiAttach bool) (device *Device, err error)
+
+	// MarkNameAsBad excludes name from the candidate set on nodeID for a
+	// while, so a name that just failed to attach (e.g. because it's
+	// already in use out-of-band) isn't immediately reselected.
+	MarkNameAsBad(nodeID, name string)
 }
 
 type deviceManager struct {
@@ -54,6 +86,15 @@ type deviceManager struct {
 	// and then get a second request before we attach the volume.
 	mux      sync.Mutex
 	inFlight inFlightAttaching
+
+	// badNames tracks device names that recently failed to attach, per
+	// node, so NewDevice can steer clear of them.
+	badNames *badNameCache
+
+	// store durably records inFlight so a controller restart between
+	// choosing a device name and the attach completing on the cloud side
+	// doesn't re-pick the same name for a different volume.
+	store Store
 }
 
 var _ DeviceManager = &deviceManager{}
@@ -83,14 +124,43 @@ func (i inFlightAttaching) GetVolume(nodeID, name string) string {
 	return i[nodeID][name]
 }
 
-func NewDeviceManager() DeviceManager {
+// NewDeviceManager reconciles whatever a previous controller process
+// persisted (see reconcile) before returning a ready-to-use
+// DeviceManager. Pass ctx with a bounded deadline if reconciliation
+// calling out to WithInstanceDescriber shouldn't block startup
+// indefinitely.
+func NewDeviceManager(ctx context.Context, opts ...Option) (DeviceManager, error) {
+	options := &deviceManagerOptions{
+		stateDir:  defaultStateDir,
+		gcHorizon: defaultReservationGCHorizon,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	store := options.store
+	if store == nil {
+		fileStore, err := NewFileStore(options.stateDir)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+
+	badNames := newBadNameCache()
+	if err := reconcile(ctx, store, options.describer, options.gcHorizon, badNames); err != nil {
+		return nil, err
+	}
+
 	return &deviceManager{
 		nameAllocator: &nameAllocator{},
 		inFlight:      make(inFlightAttaching),
-	}
+		badNames:      badNames,
+		store:         store,
+	}, nil
 }
 
-func (d *deviceManager) NewDevice(instance *computing.InstancesSetItem, volumeID string) (*Device, error) {
+func (d *deviceManager) NewDevice(instance *computing.InstancesSetItem, volumeID string, multiAttach bool) (*Device, error) {
 	d.mux.Lock()
 	defer d.mux.Unlock()
 
@@ -101,9 +171,12 @@ func (d *deviceManager) NewDevice(instance *computing.InstancesSetItem, volumeID
 	// Get device names being attached and already attached to this instance
 	inUse := d.getDeviceNamesInUse(instance)
 
-	// Check if this volume is already assigned a device on this machine
+	// Check if this volume is already assigned a device on this machine.
+	// This already only looks at inUse for this instance, so a
+	// multi-attach volume reserved on another node first doesn't show up
+	// here and doesn't block this node from getting its own letter.
 	if path := d.getPath(inUse, volumeID); path != "" {
-		return d.newBlockDevice(instance, volumeID, path, true), nil
+		return d.newBlockDevice(instance, volumeID, path, true, multiAttach), nil
 	}
 
 	nodeID, err := getInstanceID(instance)
@@ -111,6 +184,14 @@ func (d *deviceManager) NewDevice(instance *computing.InstancesSetItem, volumeID
 		return nil, err
 	}
 
+	// Exclude names that recently failed to attach on this node, so we
+	// don't immediately reselect a letter that's occupied out-of-band.
+	for name := range d.badNames.Names(nodeID) {
+		if _, ok := inUse[name]; !ok {
+			inUse[name] = ""
+		}
+	}
+
 	name, err := d.nameAllocator.GetNext(inUse)
 	if err != nil {
 		return nil, fmt.Errorf("could not get a free device name to assign to node %s", nodeID)
@@ -118,29 +199,41 @@ func (d *deviceManager) NewDevice(instance *computing.InstancesSetItem, volumeID
 
 	// Add the chosen device and volume to the "attachments in progress" map
 	d.inFlight.Add(nodeID, volumeID, name)
+	if err := d.store.Save(nodeID, name, reservation{VolumeID: volumeID, ReservedAt: time.Now()}); err != nil {
+		klog.Errorf("Error persisting in-flight reservation: %v", err)
+	}
 
-	return d.newBlockDevice(instance, volumeID, name, false), nil
+	return d.newBlockDevice(instance, volumeID, name, false, multiAttach), nil
 }
 
-func (d *deviceManager) GetDevice(instance *computing.InstancesSetItem, volumeID string) (*Device, error) {
+func (d *deviceManager) GetDevice(instance *computing.InstancesSetItem, volumeID string, multiAttach bool) (*Device, error) {
 	d.mux.Lock()
 	defer d.mux.Unlock()
 
 	inUse := d.getDeviceNamesInUse(instance)
 
 	if path := d.getPath(inUse, volumeID); path != "" {
-		return d.newBlockDevice(instance, volumeID, path, true), nil
+		return d.newBlockDevice(instance, volumeID, path, true, multiAttach), nil
 	}
 
-	return d.newBlockDevice(instance, volumeID, "", false), nil
+	return d.newBlockDevice(instance, volumeID, "", false, multiAttach), nil
 }
 
-func (d *deviceManager) newBlockDevice(instance *computing.InstancesSetItem, volumeID string, path string, isAlreadyAssigned bool) *Device {
+// MarkNameAsBad excludes name from the candidate set on nodeID for a while.
+// Call it when the cloud API rejects an AttachVolume call because the name
+// is already in use, so the next retry picks a different letter instead of
+// looping on the same doomed one. Nothing in this package calls it itself.
+func (d *deviceManager) MarkNameAsBad(nodeID, name string) {
+	d.badNames.Mark(nodeID, name)
+}
+
+func (d *deviceManager) newBlockDevice(instance *computing.InstancesSetItem, volumeID string, path string, isAlreadyAssigned bool, multiAttach bool) *Device {
 	device := &Device{
 		Instance:          instance,
 		Path:              path,
 		VolumeID:          volumeID,
 		IsAlreadyAssigned: isAlreadyAssigned,
+		MultiAttach:       multiAttach,
 
 		isTainted: false,
 	}
@@ -175,6 +268,9 @@ func (d *deviceManager) release(device *Device) error {
 
 	klog.V(5).Infof("Releasing in-process attachment entry: %v -> volume %s", device.Path, device.VolumeID)
 	d.inFlight.Del(nodeID, device.Path)
+	if err := d.store.Delete(nodeID, device.Path); err != nil {
+		klog.Errorf("Error deleting persisted in-flight reservation: %v", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,20 @@
+package devicemanager
+
+// MultiAttachVolumeTypes lists the NIFCLOUD additional-storage volume
+// types that may be attached to more than one node at once, i.e. that
+// are safe to request with AccessMode MULTI_NODE_MULTI_WRITER. Until
+// NIFCLOUD exposes a shared-disk SKU analogous to EBS io2 multi-attach
+// this is empty; it exists so the allowlist has one place to grow from
+// once that becomes available.
+var MultiAttachVolumeTypes = map[string]bool{}
+
+// IsMultiAttachAllowed reports whether volumeType may be requested with
+// AccessMode MULTI_NODE_MULTI_WRITER. The controller's CreateVolume path
+// should reject the StorageClass parameter multiAttachEnabled: "true"
+// for any other volume type, and ControllerPublishVolume should reject
+// Filesystem-mode multi-attach requests outright regardless of volume
+// type, since ordinary filesystems aren't safe to mount on more than one
+// node at a time.
+func IsMultiAttachAllowed(volumeType string) bool {
+	return MultiAttachVolumeTypes[volumeType]
+}
@@ -0,0 +1,52 @@
+package devicemanager
+
+import (
+	"testing"
+
+	"github.com/alice02/nifcloud-sdk-go-v2/service/computing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestIsMultiAttachAllowed(t *testing.T) {
+	if IsMultiAttachAllowed("standard") {
+		t.Fatal("expected an unlisted volume type to be disallowed")
+	}
+
+	MultiAttachVolumeTypes["shared-disk"] = true
+	defer delete(MultiAttachVolumeTypes, "shared-disk")
+
+	if !IsMultiAttachAllowed("shared-disk") {
+		t.Fatal("expected a listed volume type to be allowed")
+	}
+}
+
+func TestNewDeviceAndGetDeviceAgreeOnMultiAttach(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	dm := &deviceManager{
+		nameAllocator: &nameAllocator{},
+		inFlight:      make(inFlightAttaching),
+		badNames:      newBadNameCache(),
+		store:         store,
+	}
+
+	instance := &computing.InstancesSetItem{InstanceId: aws.String("node-a")}
+
+	created, err := dm.NewDevice(instance, "vol-1", true)
+	if err != nil {
+		t.Fatalf("NewDevice: %v", err)
+	}
+	if !created.MultiAttach {
+		t.Fatal("expected the freshly reserved device to report MultiAttach true")
+	}
+
+	got, err := dm.GetDevice(instance, "vol-1", true)
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	if !got.MultiAttach {
+		t.Fatal("expected GetDevice to agree with NewDevice on MultiAttach for the same volume")
+	}
+}
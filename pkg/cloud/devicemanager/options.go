@@ -0,0 +1,47 @@
+package devicemanager
+
+import "time"
+
+// Option configures the Store a DeviceManager persists in-flight
+// reservations to, and how aggressively stale ones are garbage collected.
+type Option func(*deviceManagerOptions)
+
+type deviceManagerOptions struct {
+	store     Store
+	stateDir  string
+	gcHorizon time.Duration
+	describer InstanceDescriber
+}
+
+// WithStateDir points the default file-backed Store at stateDir instead
+// of defaultStateDir. Ignored if WithStore is also given.
+func WithStateDir(stateDir string) Option {
+	return func(o *deviceManagerOptions) {
+		o.stateDir = stateDir
+	}
+}
+
+// WithStore overrides the persistence backend entirely, e.g. with
+// NewConfigMapStore for controllers that can't rely on local disk.
+func WithStore(store Store) Option {
+	return func(o *deviceManagerOptions) {
+		o.store = store
+	}
+}
+
+// WithReservationGCHorizon overrides how old an unconfirmed persisted
+// reservation can get before it's dropped at startup as abandoned.
+func WithReservationGCHorizon(horizon time.Duration) Option {
+	return func(o *deviceManagerOptions) {
+		o.gcHorizon = horizon
+	}
+}
+
+// WithInstanceDescriber lets NewDeviceManager confirm persisted
+// reservations against real attachment state (via a fresh
+// DescribeInstances) at startup, instead of relying on gcHorizon alone.
+func WithInstanceDescriber(describer InstanceDescriber) Option {
+	return func(o *deviceManagerOptions) {
+		o.describer = describer
+	}
+}
@@ -0,0 +1,86 @@
+package devicemanager
+
+import (
+	"context"
+	"time"
+
+	"github.com/alice02/nifcloud-sdk-go-v2/service/computing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"k8s.io/klog"
+)
+
+// InstanceDescriber is the minimum needed from the cloud client to
+// confirm, at startup, whether a persisted reservation's attach actually
+// completed while this controller was down. *cloud.Cloud satisfies this.
+type InstanceDescriber interface {
+	GetInstance(ctx context.Context, instanceID string) (*computing.InstancesSetItem, error)
+}
+
+// reconcile walks every reservation a previous controller process
+// persisted and decides what to do with each:
+//
+//   - if describer confirms (via a fresh DescribeInstances) that the
+//     volume is already attached under the reserved name, the
+//     reservation is redundant and is discarded;
+//   - otherwise — whether because it couldn't be confirmed, or no
+//     describer was configured at all — the device name is excluded from
+//     new allocations via badNames until gcHorizon after it was
+//     reserved, but it is deliberately NOT added to inFlight. A
+//     reservation surviving only on disk is no guarantee that
+//     AttachVolume was ever even called before the crash; trusting it as
+//     "already assigned" would make a retried NewDevice report
+//     IsAlreadyAssigned for a volume that was never actually attached,
+//     and the caller would skip attaching it for real.
+func reconcile(ctx context.Context, store Store, describer InstanceDescriber, gcHorizon time.Duration, badNames *badNameCache) error {
+	persisted, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for nodeID, names := range persisted {
+		var instance *computing.InstancesSetItem
+		if describer != nil {
+			instance, err = describer.GetInstance(ctx, nodeID)
+			if err != nil {
+				klog.Warningf("Could not reconcile in-flight reservations for node %s against live state: %v", nodeID, err)
+				instance = nil
+			}
+		}
+
+		for name, r := range names {
+			if attachConfirmed(instance, name, r.VolumeID) {
+				klog.V(4).Infof("Reservation %s -> %s on node %s is already attached; discarding persisted copy", name, r.VolumeID, nodeID)
+				if err := store.Delete(nodeID, name); err != nil {
+					klog.Errorf("Error deleting confirmed reservation: %v", err)
+				}
+				continue
+			}
+
+			if now.Sub(r.ReservedAt) > gcHorizon {
+				klog.Warningf("Dropping stale in-flight reservation %s -> %s on node %s (reserved at %v)", name, r.VolumeID, nodeID, r.ReservedAt)
+				if err := store.Delete(nodeID, name); err != nil {
+					klog.Errorf("Error deleting stale reservation: %v", err)
+				}
+				continue
+			}
+
+			badNames.MarkUntil(nodeID, name, r.ReservedAt.Add(gcHorizon))
+		}
+	}
+	return nil
+}
+
+// attachConfirmed reports whether instance's BlockDeviceMapping shows
+// volumeID genuinely attached under name.
+func attachConfirmed(instance *computing.InstancesSetItem, name, volumeID string) bool {
+	if instance == nil {
+		return false
+	}
+	for _, bd := range instance.BlockDeviceMapping {
+		if aws.StringValue(bd.DeviceName) == name && aws.StringValue(bd.Ebs.VolumeId) == volumeID {
+			return true
+		}
+	}
+	return false
+}
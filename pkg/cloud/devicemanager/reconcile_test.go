@@ -0,0 +1,137 @@
+package devicemanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alice02/nifcloud-sdk-go-v2/service/computing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type fakeInstanceDescriber struct {
+	instances map[string]*computing.InstancesSetItem
+}
+
+func (f *fakeInstanceDescriber) GetInstance(_ context.Context, instanceID string) (*computing.InstancesSetItem, error) {
+	return f.instances[instanceID], nil
+}
+
+func TestReconcileDiscardsConfirmedReservations(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.Save("node-a", "/dev/sdb", reservation{VolumeID: "vol-1", ReservedAt: time.Now()}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	describer := &fakeInstanceDescriber{
+		instances: map[string]*computing.InstancesSetItem{
+			"node-a": {
+				InstanceId: aws.String("node-a"),
+				BlockDeviceMapping: []computing.BlockDeviceMappingItem0{
+					{DeviceName: aws.String("/dev/sdb"), Ebs: &computing.Ebs{VolumeId: aws.String("vol-1")}},
+				},
+			},
+		},
+	}
+
+	badNames := newBadNameCache()
+	if err := reconcile(context.Background(), store, describer, defaultReservationGCHorizon, badNames); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	all, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("expected the confirmed reservation to be discarded from the store, got %v", all)
+	}
+	if bad := badNames.Names("node-a"); len(bad) != 0 {
+		t.Fatalf("a confirmed reservation shouldn't need a name exclusion, got %v", bad)
+	}
+}
+
+func TestReconcileExcludesUnconfirmedReservationsWithoutClaimingThemAttached(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.Save("node-a", "/dev/sdb", reservation{VolumeID: "vol-1", ReservedAt: time.Now()}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	// The instance exists but has nothing attached: the attach never
+	// completed (or was never even issued) before the crash.
+	describer := &fakeInstanceDescriber{
+		instances: map[string]*computing.InstancesSetItem{
+			"node-a": {InstanceId: aws.String("node-a")},
+		},
+	}
+
+	badNames := newBadNameCache()
+	if err := reconcile(context.Background(), store, describer, defaultReservationGCHorizon, badNames); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	bad := badNames.Names("node-a")
+	if !bad["/dev/sdb"] {
+		t.Fatalf("expected /dev/sdb to be excluded from new allocations, got %v", bad)
+	}
+
+	all, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, ok := all["node-a"]["/dev/sdb"]; !ok {
+		t.Fatal("an unconfirmed-but-still-fresh reservation should be left on disk, not deleted")
+	}
+}
+
+func TestReconcileDropsStaleReservationsPastTheGCHorizon(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.Save("node-a", "/dev/sdb", reservation{VolumeID: "vol-1", ReservedAt: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	badNames := newBadNameCache()
+	if err := reconcile(context.Background(), store, nil, 5*time.Minute, badNames); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	if bad := badNames.Names("node-a"); len(bad) != 0 {
+		t.Fatalf("a reservation past the GC horizon should not be excluded either, got %v", bad)
+	}
+	all, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("expected the stale reservation to be dropped from the store, got %v", all)
+	}
+}
+
+func TestReconcileWithoutADescriberStillExcludesRatherThanClaimsAttached(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.Save("node-a", "/dev/sdb", reservation{VolumeID: "vol-1", ReservedAt: time.Now()}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	badNames := newBadNameCache()
+	if err := reconcile(context.Background(), store, nil, defaultReservationGCHorizon, badNames); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	bad := badNames.Names("node-a")
+	if !bad["/dev/sdb"] {
+		t.Fatalf("expected /dev/sdb excluded even with no describer configured, got %v", bad)
+	}
+}
@@ -0,0 +1,34 @@
+package devicemanager
+
+import "time"
+
+// reservation is a durably-persisted record of a device name chosen for a
+// volume on a node, kept until the attach completes (Delete) or it is
+// garbage collected as stale.
+type reservation struct {
+	VolumeID   string    `json:"volumeID"`
+	ReservedAt time.Time `json:"reservedAt"`
+}
+
+// Store persists in-flight attach reservations so that a controller
+// restart between NewDevice choosing a device name and the NIFCLOUD
+// AttachVolume call completing doesn't re-pick the same name for a
+// different volume.
+type Store interface {
+	// Load returns every reservation currently on record, keyed by
+	// nodeID and device name.
+	Load() (map[string]map[string]reservation, error)
+
+	// Save durably records a single reservation.
+	Save(nodeID, name string, r reservation) error
+
+	// Delete removes a reservation once its attach has completed (or
+	// been abandoned).
+	Delete(nodeID, name string) error
+}
+
+// defaultReservationGCHorizon is how old a persisted reservation can get
+// before it's assumed abandoned (its controller crashed and never came
+// back) and is dropped at startup rather than blocking a device name
+// forever.
+const defaultReservationGCHorizon = 15 * time.Minute
@@ -0,0 +1,19 @@
+package cloud
+
+import "time"
+
+// Option configures a Cloud created by New.
+type Option func(*cloudOptions)
+
+type cloudOptions struct {
+	createVolumeTokenTTL time.Duration
+}
+
+// WithCreateVolumeTokenTTL overrides how long CreateVolume's idempotency
+// token cache reuses a token for retries of the same request before a
+// new one would be minted anyway. Defaults to createVolumeTokenTTL.
+func WithCreateVolumeTokenTTL(ttl time.Duration) Option {
+	return func(o *cloudOptions) {
+		o.createVolumeTokenTTL = ttl
+	}
+}
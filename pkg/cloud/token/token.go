@@ -0,0 +1,137 @@
+// Package token generates deterministic client tokens for idempotent
+// NIFCLOUD CreateVolume calls, and detects when a CSI retry's parameters
+// have drifted enough from the original request that the old token must
+// be abandoned rather than reused.
+package token
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultTTL is how long a minted token is reused for retries of the
+// same CreateVolumeRequest before it's allowed to expire. This should
+// comfortably cover the CSI sidecar's own retry window.
+const defaultTTL = 10 * time.Minute
+
+// Params is the subset of a CreateVolumeRequest that determines whether
+// two calls are "the same" request for idempotency purposes. Two calls
+// with the same Name but different Params are a parameter mismatch, not
+// a retry.
+type Params struct {
+	Name             string
+	SizeGiB          int64
+	VolumeType       string
+	AvailabilityZone string
+	Encrypted        bool
+}
+
+func (p Params) hash(generation int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%s|%t|%d", p.Name, p.SizeGiB, p.VolumeType, p.AvailabilityZone, p.Encrypted, generation)))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+// entry is a cached token together with the params it was minted for, so
+// a later call for the same Name but with different Params can be
+// detected as a parameter mismatch instead of silently reusing the
+// stale token.
+type entry struct {
+	token     string
+	params    Params
+	expiresAt time.Time
+}
+
+// Cache hands out a deterministic client token per CreateVolumeRequest
+// name: the same Params within the TTL window gets the same token back
+// (so CSI retries are idempotent), while a Name reused with different
+// Params gets a fresh token (so it isn't mistaken for the earlier
+// request and doesn't loop forever against it).
+type Cache struct {
+	ttl time.Duration
+
+	mux         sync.Mutex
+	entries     map[string]entry
+	generations map[string]int
+}
+
+// NewCache creates a Cache whose entries are reused for ttl. A ttl of
+// zero uses defaultTTL.
+func NewCache(ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &Cache{
+		ttl:         ttl,
+		entries:     make(map[string]entry),
+		generations: make(map[string]int),
+	}
+}
+
+// Token returns the client token to use for params. mismatch reports
+// whether a still-live token exists for this Name but was minted for
+// different Params, meaning this call supersedes an in-flight or
+// recently completed request rather than retrying it; the returned
+// token is fresh either way.
+func (c *Cache) Token(params Params) (tok string, mismatch bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now()
+	if e, ok := c.entries[params.Name]; ok {
+		if now.Before(e.expiresAt) {
+			if e.params == params {
+				return e.token, false
+			}
+			mismatch = true
+		} else {
+			// The cached token has expired: move to the next generation so
+			// the token minted below differs from the expired one.
+			c.generations[params.Name]++
+		}
+	}
+
+	tok = c.mint(params)
+	return tok, mismatch
+}
+
+// Refresh forces a brand-new token for params even though Params itself
+// is unchanged from what's cached. Use this when the API, not just the
+// cache, is the one reporting IdempotentParameterMismatch: that means an
+// orphan volume is occupying the previous token, and reusing it would
+// just hit the same conflict again.
+func (c *Cache) Refresh(params Params) string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.generations[params.Name]++
+	return c.mint(params)
+}
+
+// mint computes and caches a token for params at the name's current
+// generation. Callers must hold c.mux.
+func (c *Cache) mint(params Params) string {
+	tok := params.hash(c.generations[params.Name])
+	c.entries[params.Name] = entry{token: tok, params: params, expiresAt: time.Now().Add(c.ttl)}
+	return tok
+}
+
+// Forget drops the cached token for name, e.g. once the volume it refers
+// to has been deleted and the name is free to be reused for an unrelated
+// request.
+func (c *Cache) Forget(name string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.entries, name)
+	delete(c.generations, name)
+}
+
+// MismatchError builds the error CreateVolume should return when
+// NIFCLOUD still reports an IdempotentParameterMismatch after the token
+// was refreshed, i.e. an orphan volume left behind by an earlier partial
+// create is genuinely blocking this request and retrying won't help.
+func MismatchError(name, conflictingVolumeID string) error {
+	return fmt.Errorf("CreateVolume %q conflicts with existing volume %q created with different parameters; delete it or choose a different name", name, conflictingVolumeID)
+}
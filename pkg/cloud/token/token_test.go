@@ -0,0 +1,73 @@
+package token
+
+import "testing"
+
+func TestTokenReusedForIdenticalRetry(t *testing.T) {
+	c := NewCache(defaultTTL)
+	params := Params{Name: "pvc-1", SizeGiB: 20, VolumeType: "standard"}
+
+	first, mismatch := c.Token(params)
+	if mismatch {
+		t.Fatal("first call reported a mismatch")
+	}
+
+	second, mismatch := c.Token(params)
+	if mismatch {
+		t.Fatal("identical retry reported a mismatch")
+	}
+	if first != second {
+		t.Fatalf("got different tokens for identical retries: %q vs %q", first, second)
+	}
+}
+
+func TestTokenRefreshedOnParameterMismatch(t *testing.T) {
+	c := NewCache(defaultTTL)
+	name := "pvc-1"
+
+	original, _ := c.Token(Params{Name: name, SizeGiB: 20, VolumeType: "standard"})
+
+	refreshed, mismatch := c.Token(Params{Name: name, SizeGiB: 40, VolumeType: "standard"})
+	if !mismatch {
+		t.Fatal("expected a mismatch when size changed for the same name")
+	}
+	if refreshed == original {
+		t.Fatal("expected a new token after a parameter mismatch, got the same one")
+	}
+}
+
+func TestRefreshMintsANewTokenForUnchangedParams(t *testing.T) {
+	c := NewCache(defaultTTL)
+	params := Params{Name: "pvc-1", SizeGiB: 20, VolumeType: "standard"}
+
+	original, _ := c.Token(params)
+	refreshed := c.Refresh(params)
+	if refreshed == original {
+		t.Fatal("expected Refresh to mint a new token even though params didn't change")
+	}
+
+	// The refreshed token should now be what Token hands back for a
+	// same-params retry.
+	again, mismatch := c.Token(params)
+	if mismatch {
+		t.Fatal("retry with the same params right after Refresh should not be reported as a mismatch")
+	}
+	if again != refreshed {
+		t.Fatalf("got %q, want the refreshed token %q", again, refreshed)
+	}
+}
+
+func TestForgetResetsNameWithoutMismatch(t *testing.T) {
+	c := NewCache(defaultTTL)
+	params := Params{Name: "pvc-1", SizeGiB: 20, VolumeType: "standard"}
+
+	first, _ := c.Token(params)
+	c.Forget(params.Name)
+	second, mismatch := c.Token(params)
+
+	if mismatch {
+		t.Fatal("reusing a name after Forget should not be reported as a mismatch")
+	}
+	if first != second {
+		t.Fatal("the same params should still hash to the same token after Forget")
+	}
+}